@@ -0,0 +1,190 @@
+/*
+ * Copyright 2017-2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDetectAndOpenGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte("hello world")); err != nil {
+		t.Fatalf("gzip.Write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+
+	r, err := detectAndOpen(&buf)
+	if err != nil {
+		t.Fatalf("detectAndOpen: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("got %q, want %q", got, "hello world")
+	}
+}
+
+func TestDetectAndOpenUncompressed(t *testing.T) {
+	in := "_:a <name> \"bob\" .\n"
+	r, err := detectAndOpen(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("detectAndOpen: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != in {
+		t.Fatalf("got %q, want %q", got, in)
+	}
+}
+
+func TestDetectAndOpenShortInput(t *testing.T) {
+	// Shorter than maxMagicLen; detectAndOpen must still replay it intact
+	// rather than erroring out of io.ReadFull's ErrUnexpectedEOF.
+	in := "ab"
+	r, err := detectAndOpen(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("detectAndOpen: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != in {
+		t.Fatalf("got %q, want %q", got, in)
+	}
+}
+
+func TestDetectAndOpenEmptyInput(t *testing.T) {
+	r, err := detectAndOpen(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("detectAndOpen: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got %q, want empty", got)
+	}
+}
+
+func TestDecompressorsMagicNumbers(t *testing.T) {
+	want := [][]byte{
+		{0x1f, 0x8b},
+		{0x42, 0x5a, 0x68},
+		{0x28, 0xb5, 0x2f, 0xfd},
+		{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},
+	}
+	if len(decompressors) != len(want) {
+		t.Fatalf("got %d decompressors, want %d", len(decompressors), len(want))
+	}
+	for _, magic := range want {
+		found := false
+		for _, d := range decompressors {
+			if bytes.Equal(d.magic, magic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no decompressor registered for magic %x", magic)
+		}
+		if len(magic) > maxMagicLen {
+			t.Errorf("magic %x is longer than maxMagicLen (%d)", magic, maxMagicLen)
+		}
+	}
+}
+
+func TestNdjsonChunkerChunk(t *testing.T) {
+	input := "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n"
+	r := bufio.NewReader(strings.NewReader(input))
+	var c ndjsonChunker
+
+	batch, err := c.Chunk(r)
+	if err != io.EOF {
+		t.Fatalf("Chunk: got err %v, want io.EOF", err)
+	}
+	if batch.String() != input {
+		t.Fatalf("got %q, want %q", batch.String(), input)
+	}
+}
+
+// TestNdjsonChunkerChunkBufferFull forces bufio.ErrBufferFull by handing
+// Chunk a reader whose buffer is too small to hold a single line, exercising
+// the fallback path that finishes the line with ReadString instead.
+func TestNdjsonChunkerChunkBufferFull(t *testing.T) {
+	line := strings.Repeat("x", 100) + "\n"
+	r := bufio.NewReaderSize(strings.NewReader(line), 16)
+	var c ndjsonChunker
+
+	batch, err := c.Chunk(r)
+	if err != io.EOF {
+		t.Fatalf("Chunk: got err %v, want io.EOF", err)
+	}
+	if batch.String() != line {
+		t.Fatalf("got %q, want %q", batch.String(), line)
+	}
+}
+
+func TestFormatForExt(t *testing.T) {
+	if _, ok := formatForExt("data.rdf"); !ok {
+		t.Error("expected data.rdf to match the built-in rdf format")
+	}
+	if _, ok := formatForExt("data.rdf.gz"); !ok {
+		t.Error("expected data.rdf.gz to match via compressedSuffixes")
+	}
+	if _, ok := formatForExt("data.ndjson.zst"); !ok {
+		t.Error("expected data.ndjson.zst to match via compressedSuffixes")
+	}
+	if _, ok := formatForExt("data.unknownformat"); ok {
+		t.Error("expected data.unknownformat to match nothing")
+	}
+}
+
+func TestRegisterFormatPluggable(t *testing.T) {
+	RegisterFormat("test-csv", []string{".csv"}, func() Chunker { return &rdfChunker{} })
+	defer func() {
+		formatsMu.Lock()
+		delete(formats, "test-csv")
+		formatsMu.Unlock()
+	}()
+
+	f, ok := formatForExt("data.csv")
+	if !ok {
+		t.Fatal("expected data.csv to match the newly registered format")
+	}
+	if _, ok := f.newChunker().(*rdfChunker); !ok {
+		t.Error("expected formatForExt to return the registered factory's Chunker")
+	}
+
+	if _, ok := formatForExt("data.csv.bz2"); !ok {
+		t.Error("expected data.csv.bz2 to match via compressedSuffixes")
+	}
+}